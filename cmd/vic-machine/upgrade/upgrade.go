@@ -15,15 +15,20 @@
 package upgrade
 
 import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"path"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
 
 	"github.com/urfave/cli"
+	"github.com/vmware/vic/lib/config"
 	"github.com/vmware/vic/lib/install/data"
 	"github.com/vmware/vic/lib/install/management"
 	"github.com/vmware/vic/lib/install/validate"
+	"github.com/vmware/vic/lib/portlayer/storage"
 	"github.com/vmware/vic/pkg/errors"
 	"github.com/vmware/vic/pkg/trace"
 	"github.com/vmware/vic/pkg/vsphere/vm"
@@ -31,10 +36,29 @@ import (
 	"golang.org/x/net/context"
 )
 
+// dryRunReportPath is where --dry-run writes its preflight findings. It's
+// fixed rather than user-configurable because it's meant to be read
+// right after the command exits, not archived.
+const dryRunReportPath = "upgrade-preflight.json"
+
 // Upgrade has all input parameters for vic-machine upgrade command
 type Upgrade struct {
 	*data.Data
 
+	// ImageEncryptionRecipients are raw "<pgp|jwe>:<path>" values from
+	// --image-encryption-recipient, parsed by Run via
+	// storage.ParseRecipients.
+	ImageEncryptionRecipients cli.StringSlice
+
+	// DryRun runs preflight validation and writes dryRunReportPath
+	// without mutating the VCH.
+	DryRun bool
+
+	// RollbackOnTimeout rolls back to the pre-upgrade checkpoint if
+	// Upgrade doesn't complete within Timeout, in addition to the
+	// existing rollback-on-error behavior.
+	RollbackOnTimeout bool
+
 	executor *management.Dispatcher
 }
 
@@ -54,6 +78,21 @@ func (u *Upgrade) Flags() []cli.Flag {
 			Usage:       "Time to wait for upgrade",
 			Destination: &u.Timeout,
 		},
+		cli.StringSliceFlag{
+			Name:  "image-encryption-recipient",
+			Value: &u.ImageEncryptionRecipients,
+			Usage: "Recipient to encrypt image layers to, as <pgp|jwe>:<path-to-public-key>. Repeatable.",
+		},
+		cli.BoolFlag{
+			Name:        "dry-run",
+			Usage:       fmt.Sprintf("Run preflight compatibility checks and write %s without changing the VCH", dryRunReportPath),
+			Destination: &u.DryRun,
+		},
+		cli.BoolFlag{
+			Name:        "rollback-on-timeout",
+			Usage:       "Roll back to the pre-upgrade checkpoint if upgrade doesn't finish within --timeout",
+			Destination: &u.RollbackOnTimeout,
+		},
 	}
 	flags = append(
 		append(
@@ -135,25 +174,106 @@ func (u *Upgrade) Run(cli *cli.Context) error {
 		log.Error(err)
 		return errors.New("upgrade failed")
 	}
+	vchConfigRaw, err := executor.GetVCHConfigRaw(vch)
+	if err != nil {
+		log.Error("Failed to get Virtual Container Host configuration")
+		log.Error(err)
+		return errors.New("upgrade failed")
+	}
 	executor.InitDiagnosticLogs(vchConfig)
 
-	// FIXME: add vchConfig validation here, to make the old vch config is compatible with new version
+	imageEncryptionRecipients, err := storage.ParseRecipients(u.ImageEncryptionRecipients.Value())
+	if err != nil {
+		log.Error(err)
+		return errors.New("upgrade failed")
+	}
 
 	vConfig := validator.AddDeprecatedFields(ctx, vchConfig, u.Data)
 	vConfig.ImageFiles = images
 	vConfig.ApplianceISO = path.Base(u.ApplianceISO)
 	vConfig.BootstrapISO = path.Base(u.BootstrapISO)
 	vConfig.RollbackTimeout = u.Timeout
+	vConfig.ImageEncryptionRecipients = imageEncryptionRecipients
+
+	log.Info("Running preflight compatibility checks")
+	findings := validate.PreflightUpgrade(vchConfig, vchConfigRaw, vConfig)
+	for _, finding := range findings {
+		log.Info(finding.String())
+	}
 
-	if err = executor.Upgrade(vch, vchConfig, vConfig); err != nil {
-		// upgrade failed
-		executor.CollectDiagnosticLogs()
-		if err == nil {
-			err = errors.New("upgrade failed")
+	if u.DryRun {
+		report, err := json.MarshalIndent(findings, "", "  ")
+		if err != nil {
+			return err
 		}
+		if err := ioutil.WriteFile(dryRunReportPath, report, 0644); err != nil {
+			return fmt.Errorf("writing %s: %s", dryRunReportPath, err)
+		}
+		log.Infof("Dry run: wrote preflight report to %s, VCH unchanged", dryRunReportPath)
+		return nil
+	}
+
+	if validate.HasErrors(findings) && !u.Force {
+		log.Error("Upgrade cannot continue - preflight checks found compatibility errors; pass --force to proceed anyway")
+		return errors.New("upgrade failed")
+	}
+
+	if err := runUpgrade(ctx, executor, vch, vchConfig, vConfig, u.RollbackOnTimeout); err != nil {
 		return err
 	}
-	log.Infof("Completed successfully")
 
+	log.Infof("Completed successfully")
 	return nil
 }
+
+// upgradeExecutor is the subset of *management.Dispatcher that runUpgrade
+// needs, so the checkpoint -> upgrade -> rollback-on-failure wiring below
+// can be tested against a fake instead of a real vCenter session.
+type upgradeExecutor interface {
+	Checkpoint(ctx context.Context, vch *vm.VirtualMachine) (*management.Snapshot, error)
+	Upgrade(vch *vm.VirtualMachine, oldConfig *config.VirtualContainerHostConfigSpec, newConfig *data.InstallerData) error
+	RemoveCheckpoint(ctx context.Context, snap *management.Snapshot) error
+	CollectDiagnosticLogs()
+	Rollback(ctx context.Context, snap *management.Snapshot) error
+}
+
+// runUpgrade checkpoints vch, applies vConfig, and - if the upgrade fails -
+// rolls back to that checkpoint, unless ctx timed out and rollbackOnTimeout
+// is false. It's split out from Run so the wiring can be driven with a fake
+// executor in tests.
+func runUpgrade(ctx context.Context, executor upgradeExecutor, vch *vm.VirtualMachine, vchConfig *config.VirtualContainerHostConfigSpec, vConfig *data.InstallerData, rollbackOnTimeout bool) error {
+	checkpoint, err := executor.Checkpoint(ctx, vch)
+	if err != nil {
+		log.Error("Failed to checkpoint Virtual Container Host before upgrading")
+		log.Error(err)
+		return errors.New("upgrade failed")
+	}
+
+	err = executor.Upgrade(vch, vchConfig, vConfig)
+	if err == nil {
+		if rerr := executor.RemoveCheckpoint(ctx, checkpoint); rerr != nil {
+			log.Warnf("Upgrade succeeded but failed to remove the pre-upgrade checkpoint: %s", rerr)
+		}
+		return nil
+	}
+
+	// upgrade failed
+	executor.CollectDiagnosticLogs()
+
+	rollback := true
+	if ctx.Err() == context.DeadlineExceeded {
+		rollback = rollbackOnTimeout
+	}
+
+	if rollback {
+		log.Error("Upgrade failed - rolling back to the pre-upgrade checkpoint")
+		if rerr := executor.Rollback(context.Background(), checkpoint); rerr != nil {
+			log.Error("Rollback failed; the VCH may be left half-migrated")
+			log.Error(rerr)
+		}
+	} else {
+		log.Error("Upgrade timed out - leaving the VCH as-is; rerun with --rollback-on-timeout to roll back automatically")
+	}
+
+	return err
+}
@@ -0,0 +1,131 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package upgrade
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/vmware/vic/lib/config"
+	"github.com/vmware/vic/lib/install/data"
+	"github.com/vmware/vic/lib/install/management"
+	"github.com/vmware/vic/pkg/vsphere/vm"
+
+	"golang.org/x/net/context"
+)
+
+// fakeUpgradeExecutor is a minimal in-memory upgradeExecutor used to
+// exercise runUpgrade's checkpoint/upgrade/rollback wiring without a real
+// Dispatcher or vCenter session.
+type fakeUpgradeExecutor struct {
+	upgradeErr error
+
+	checkpointed      bool
+	removedCheckpoint bool
+	rolledBack        bool
+	collectedLogs     bool
+}
+
+func (f *fakeUpgradeExecutor) Checkpoint(ctx context.Context, vch *vm.VirtualMachine) (*management.Snapshot, error) {
+	f.checkpointed = true
+	return &management.Snapshot{}, nil
+}
+
+func (f *fakeUpgradeExecutor) Upgrade(vch *vm.VirtualMachine, oldConfig *config.VirtualContainerHostConfigSpec, newConfig *data.InstallerData) error {
+	return f.upgradeErr
+}
+
+func (f *fakeUpgradeExecutor) RemoveCheckpoint(ctx context.Context, snap *management.Snapshot) error {
+	f.removedCheckpoint = true
+	return nil
+}
+
+func (f *fakeUpgradeExecutor) CollectDiagnosticLogs() {
+	f.collectedLogs = true
+}
+
+func (f *fakeUpgradeExecutor) Rollback(ctx context.Context, snap *management.Snapshot) error {
+	f.rolledBack = true
+	return nil
+}
+
+func TestRunUpgradeSuccessRemovesCheckpoint(t *testing.T) {
+	executor := &fakeUpgradeExecutor{}
+
+	if err := runUpgrade(context.Background(), executor, nil, &config.VirtualContainerHostConfigSpec{}, &data.InstallerData{}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if !executor.checkpointed {
+		t.Fatal("expected runUpgrade to checkpoint before upgrading")
+	}
+	if !executor.removedCheckpoint {
+		t.Fatal("expected a successful upgrade to remove its checkpoint")
+	}
+	if executor.rolledBack {
+		t.Fatal("expected a successful upgrade not to roll back")
+	}
+}
+
+func TestRunUpgradeFailureRollsBack(t *testing.T) {
+	executor := &fakeUpgradeExecutor{upgradeErr: fmt.Errorf("upgrade exploded")}
+
+	err := runUpgrade(context.Background(), executor, nil, &config.VirtualContainerHostConfigSpec{}, &data.InstallerData{}, false)
+	if err == nil {
+		t.Fatal("expected runUpgrade to return the upgrade error")
+	}
+
+	if !executor.collectedLogs {
+		t.Fatal("expected a failed upgrade to collect diagnostic logs")
+	}
+	if !executor.rolledBack {
+		t.Fatal("expected a mid-upgrade failure to roll back to the checkpoint")
+	}
+	if executor.removedCheckpoint {
+		t.Fatal("expected a failed upgrade not to remove its checkpoint")
+	}
+}
+
+func TestRunUpgradeTimeoutWithoutRollbackOnTimeout(t *testing.T) {
+	executor := &fakeUpgradeExecutor{upgradeErr: fmt.Errorf("upgrade exploded")}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	<-ctx.Done()
+
+	if err := runUpgrade(ctx, executor, nil, &config.VirtualContainerHostConfigSpec{}, &data.InstallerData{}, false); err == nil {
+		t.Fatal("expected runUpgrade to return the upgrade error")
+	}
+
+	if executor.rolledBack {
+		t.Fatal("expected a timed-out upgrade not to roll back when rollbackOnTimeout is false")
+	}
+}
+
+func TestRunUpgradeTimeoutWithRollbackOnTimeout(t *testing.T) {
+	executor := &fakeUpgradeExecutor{upgradeErr: fmt.Errorf("upgrade exploded")}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	<-ctx.Done()
+
+	if err := runUpgrade(ctx, executor, nil, &config.VirtualContainerHostConfigSpec{}, &data.InstallerData{}, true); err == nil {
+		t.Fatal("expected runUpgrade to return the upgrade error")
+	}
+
+	if !executor.rolledBack {
+		t.Fatal("expected a timed-out upgrade to roll back when rollbackOnTimeout is true")
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package management
+
+import (
+	"fmt"
+
+	"github.com/vmware/vic/pkg/trace"
+	"github.com/vmware/vic/pkg/vsphere/vm"
+
+	"golang.org/x/net/context"
+)
+
+// applianceSnapshotter is the subset of *vm.VirtualMachine that Checkpoint,
+// Rollback, and RemoveCheckpoint need. It's split out so the
+// snapshot/config-backup sequencing in this file can be tested against a
+// fake instead of a real appliance VM and vCenter session.
+type applianceSnapshotter interface {
+	CreateSnapshot(ctx context.Context, name, description string, memory, quiesce bool) error
+	RevertToSnapshot(ctx context.Context, name string) error
+	RemoveSnapshot(ctx context.Context, name string) error
+}
+
+// Snapshot is a point-in-time capture of a VCH appliance VM and its
+// vchConfig, taken by Checkpoint and restored by Rollback so a failed
+// Upgrade doesn't leave the VCH half-migrated.
+type Snapshot struct {
+	vch          applianceSnapshotter
+	snapshotName string
+	config       map[string]string
+	setConfig    func(map[string]string) error
+}
+
+// checkpointSnapshotName is the name Checkpoint gives the appliance VM
+// snapshot it takes. Rollback reverts to the snapshot by this name, and
+// RemoveCheckpoint (called once Upgrade succeeds) removes it by the same
+// name. Rollback does not remove the snapshot.
+const checkpointSnapshotName = "vic-machine-upgrade-checkpoint"
+
+// Checkpoint snapshots vch's appliance VM and backs up its vchConfig
+// extraConfig, so a failure partway through Upgrade can be undone with
+// Rollback instead of leaving the VCH half-migrated.
+func (d *Dispatcher) Checkpoint(ctx context.Context, vch *vm.VirtualMachine) (*Snapshot, error) {
+	defer trace.End(trace.Begin(vch.Reference().String()))
+
+	return checkpoint(ctx, vch, func() (map[string]string, error) {
+		return d.GetVCHConfigRaw(vch)
+	}, func(config map[string]string) error {
+		return d.SetVCHConfigRaw(vch, config)
+	})
+}
+
+// checkpoint implements Checkpoint against explicit collaborators so the
+// snapshot/config-backup sequencing and error wrapping can be unit
+// tested without a real appliance VM, Dispatcher, or vCenter session.
+func checkpoint(ctx context.Context, vch applianceSnapshotter, getConfig func() (map[string]string, error), setConfig func(map[string]string) error) (*Snapshot, error) {
+	config, err := getConfig()
+	if err != nil {
+		return nil, fmt.Errorf("checkpoint: reading VCH configuration: %s", err)
+	}
+
+	if err := vch.CreateSnapshot(ctx, checkpointSnapshotName, "pre-upgrade checkpoint taken by vic-machine upgrade", false, false); err != nil {
+		return nil, fmt.Errorf("checkpoint: snapshotting appliance VM: %s", err)
+	}
+
+	return &Snapshot{
+		vch:          vch,
+		snapshotName: checkpointSnapshotName,
+		config:       config,
+		setConfig:    setConfig,
+	}, nil
+}
+
+// Rollback restores the appliance VM and vchConfig captured by snap,
+// undoing everything Upgrade did since Checkpoint ran.
+func (d *Dispatcher) Rollback(ctx context.Context, snap *Snapshot) error {
+	defer trace.End(trace.Begin(snap.snapshotName))
+
+	return rollback(ctx, snap)
+}
+
+// rollback implements Rollback against the collaborators captured in snap
+// at Checkpoint time, so it can be unit tested without a real Dispatcher.
+func rollback(ctx context.Context, snap *Snapshot) error {
+	if err := snap.vch.RevertToSnapshot(ctx, snap.snapshotName); err != nil {
+		return fmt.Errorf("rollback: reverting appliance VM to %q: %s", snap.snapshotName, err)
+	}
+
+	if err := snap.setConfig(snap.config); err != nil {
+		return fmt.Errorf("rollback: restoring VCH configuration: %s", err)
+	}
+
+	return nil
+}
+
+// RemoveCheckpoint discards snap's appliance VM snapshot once an Upgrade
+// has succeeded and the checkpoint is no longer needed.
+func (d *Dispatcher) RemoveCheckpoint(ctx context.Context, snap *Snapshot) error {
+	defer trace.End(trace.Begin(snap.snapshotName))
+
+	return snap.vch.RemoveSnapshot(ctx, snap.snapshotName)
+}
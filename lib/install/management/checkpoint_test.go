@@ -0,0 +1,120 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package management
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// fakeApplianceSnapshotter is a minimal in-memory applianceSnapshotter used
+// to exercise checkpoint/Rollback without a real appliance VM.
+type fakeApplianceSnapshotter struct {
+	snapshotted bool
+	reverted    bool
+	removed     bool
+
+	revertErr error
+}
+
+func (f *fakeApplianceSnapshotter) CreateSnapshot(ctx context.Context, name, description string, memory, quiesce bool) error {
+	f.snapshotted = true
+	return nil
+}
+
+func (f *fakeApplianceSnapshotter) RevertToSnapshot(ctx context.Context, name string) error {
+	if f.revertErr != nil {
+		return f.revertErr
+	}
+	f.reverted = true
+	return nil
+}
+
+func (f *fakeApplianceSnapshotter) RemoveSnapshot(ctx context.Context, name string) error {
+	f.removed = true
+	return nil
+}
+
+func TestCheckpointThenRollbackRestoresConfig(t *testing.T) {
+	vch := &fakeApplianceSnapshotter{}
+	original := map[string]string{"guestinfo.vice./init/id": "vch-1"}
+	live := map[string]string{"guestinfo.vice./init/id": "vch-1"}
+
+	snap, err := checkpoint(context.Background(), vch,
+		func() (map[string]string, error) { return original, nil },
+		func(config map[string]string) error { live = config; return nil },
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !vch.snapshotted {
+		t.Fatal("expected Checkpoint to snapshot the appliance VM")
+	}
+
+	// Simulate the upgrade mutating the live config before it fails.
+	live = map[string]string{"guestinfo.vice./init/id": "vch-1-partially-upgraded"}
+
+	if err := rollback(context.Background(), snap); err != nil {
+		t.Fatal(err)
+	}
+	if !vch.reverted {
+		t.Fatal("expected Rollback to revert the appliance VM snapshot")
+	}
+	if !reflect.DeepEqual(live, original) {
+		t.Fatalf("expected config restored to %v, got %v", original, live)
+	}
+}
+
+func TestRollbackRevertFailureDoesNotRestoreConfig(t *testing.T) {
+	vch := &fakeApplianceSnapshotter{revertErr: fmt.Errorf("snapshot gone")}
+	original := map[string]string{"guestinfo.vice./init/id": "vch-1"}
+
+	restored := false
+	snap, err := checkpoint(context.Background(), vch,
+		func() (map[string]string, error) { return original, nil },
+		func(config map[string]string) error { restored = true; return nil },
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rollback(context.Background(), snap); err == nil {
+		t.Fatal("expected an error when reverting the snapshot fails")
+	}
+	if restored {
+		t.Fatal("expected config restore to be skipped when the snapshot revert fails")
+	}
+}
+
+func TestRemoveCheckpointRemovesSnapshot(t *testing.T) {
+	vch := &fakeApplianceSnapshotter{}
+	snap, err := checkpoint(context.Background(), vch,
+		func() (map[string]string, error) { return nil, nil },
+		func(config map[string]string) error { return nil },
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := (&Dispatcher{}).RemoveCheckpoint(context.Background(), snap); err != nil {
+		t.Fatal(err)
+	}
+	if !vch.removed {
+		t.Fatal("expected RemoveCheckpoint to remove the appliance VM snapshot")
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validate
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/vmware/vic/lib/config"
+	"github.com/vmware/vic/lib/install/data"
+	"github.com/vmware/vic/pkg/extraconfig"
+)
+
+// Level is the severity of a preflight Finding.
+type Level string
+
+const (
+	// LevelInfo findings are purely informational.
+	LevelInfo Level = "INFO"
+	// LevelWarn findings won't block an upgrade but are worth surfacing.
+	LevelWarn Level = "WARN"
+	// LevelError findings make an upgrade unsafe to proceed with unless
+	// the operator explicitly overrides with --force.
+	LevelError Level = "ERROR"
+)
+
+// Finding is a single compatibility issue PreflightUpgrade surfaced while
+// comparing a VCH's on-disk configuration against what the running
+// vic-machine binary would change it to.
+type Finding struct {
+	Level   Level
+	Field   string
+	Message string
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("[%s] %s: %s", f.Level, f.Field, f.Message)
+}
+
+// HasErrors reports whether any finding in findings is LevelError.
+func HasErrors(findings []Finding) bool {
+	for _, f := range findings {
+		if f.Level == LevelError {
+			return true
+		}
+	}
+	return false
+}
+
+// PreflightUpgrade compares oldConfig and oldConfigRaw - the parsed and
+// raw on-disk ExtraConfig of the VCH being upgraded - against newConfig,
+// what this vic-machine binary is about to apply, and reports anything
+// that could make the upgrade unsafe: fields the on-disk ExtraConfig has
+// that this version no longer recognizes, deprecated appliance ISO
+// layouts, and network backings an upgrade can't migrate between.
+// oldConfigRaw must be the raw ExtraConfig (e.g. from
+// Dispatcher.GetVCHConfigRaw), not a re-encoding of oldConfig: by the
+// time oldConfig exists, extraconfig.Decode has already silently dropped
+// anything this version's schema doesn't declare. Callers should refuse
+// to proceed if HasErrors(findings) unless the operator passed --force.
+func PreflightUpgrade(oldConfig *config.VirtualContainerHostConfigSpec, oldConfigRaw map[string]string, newConfig *data.InstallerData) []Finding {
+	var findings []Finding
+
+	for _, field := range unknownFields(oldConfigRaw) {
+		findings = append(findings, Finding{
+			Level:   LevelWarn,
+			Field:   field,
+			Message: fmt.Sprintf("field %q in the on-disk VCH configuration is not recognized by this version and will be dropped", field),
+		})
+	}
+
+	if deprecatedApplianceISOLayout(oldConfig.ApplianceISO) {
+		findings = append(findings, Finding{
+			Level:   LevelError,
+			Field:   "ApplianceISO",
+			Message: fmt.Sprintf("appliance ISO %q uses a layout this version can no longer boot from; recreate the VCH instead of upgrading it", oldConfig.ApplianceISO),
+		})
+	}
+
+	if oldConfig.ExecutionEnvironment != "" && newConfig.ExecutionEnvironment != "" &&
+		oldConfig.ExecutionEnvironment != newConfig.ExecutionEnvironment {
+		findings = append(findings, Finding{
+			Level:   LevelError,
+			Field:   "ExecutionEnvironment",
+			Message: fmt.Sprintf("cannot migrate the VCH's network backing from %q to %q as part of an upgrade", oldConfig.ExecutionEnvironment, newConfig.ExecutionEnvironment),
+		})
+	}
+
+	return findings
+}
+
+// deprecatedApplianceISONames are appliance.iso layouts from versions
+// old enough that this binary can no longer reattach to them in place.
+var deprecatedApplianceISONames = map[string]bool{
+	"appliance-staging.iso": true,
+}
+
+func deprecatedApplianceISOLayout(applianceISO string) bool {
+	return deprecatedApplianceISONames[applianceISO]
+}
+
+// unknownFields encodes a zero-valued VirtualContainerHostConfigSpec to
+// the ExtraConfig key shape this version's schema produces, and returns
+// the sorted set of keys present in oldConfigRaw - the VCH's actual
+// on-disk ExtraConfig - but not in that set. Those are fields a previous
+// version wrote that this one no longer declares.
+func unknownFields(oldConfigRaw map[string]string) []string {
+	current := make(map[string]string)
+	extraconfig.Encode(extraconfig.MapSink(current), &config.VirtualContainerHostConfigSpec{})
+
+	var unknown []string
+	for field := range oldConfigRaw {
+		if _, ok := current[field]; !ok {
+			unknown = append(unknown, field)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
@@ -0,0 +1,96 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validate
+
+import (
+	"testing"
+
+	"github.com/vmware/vic/lib/config"
+	"github.com/vmware/vic/lib/install/data"
+	"github.com/vmware/vic/pkg/extraconfig"
+)
+
+func currentConfigKeys(t *testing.T) map[string]string {
+	current := make(map[string]string)
+	extraconfig.Encode(extraconfig.MapSink(current), &config.VirtualContainerHostConfigSpec{})
+	if len(current) == 0 {
+		t.Fatal("expected the current schema to encode at least one ExtraConfig key")
+	}
+	return current
+}
+
+func TestPreflightUpgradeFlagsUnknownFields(t *testing.T) {
+	oldRaw := currentConfigKeys(t)
+	oldRaw["guestinfo.vice./removed/field"] = "some-old-value"
+
+	findings := PreflightUpgrade(&config.VirtualContainerHostConfigSpec{}, oldRaw, &data.InstallerData{})
+
+	var found bool
+	for _, f := range findings {
+		if f.Field == "guestinfo.vice./removed/field" {
+			found = true
+			if f.Level != LevelWarn {
+				t.Fatalf("expected LevelWarn for a dropped field, got %s", f.Level)
+			}
+		}
+	}
+	if !found {
+		t.Fatal("expected a finding for the field this version's schema no longer declares")
+	}
+}
+
+func TestPreflightUpgradeNoUnknownFields(t *testing.T) {
+	oldRaw := currentConfigKeys(t)
+
+	findings := PreflightUpgrade(&config.VirtualContainerHostConfigSpec{}, oldRaw, &data.InstallerData{})
+
+	for _, f := range findings {
+		if f.Level == LevelWarn {
+			t.Fatalf("expected no unknown-field findings when oldRaw only has current keys, got %v", f)
+		}
+	}
+}
+
+func TestPreflightUpgradeDeprecatedApplianceISO(t *testing.T) {
+	oldConfig := &config.VirtualContainerHostConfigSpec{ApplianceISO: "appliance-staging.iso"}
+
+	findings := PreflightUpgrade(oldConfig, currentConfigKeys(t), &data.InstallerData{})
+
+	if !HasErrors(findings) {
+		t.Fatal("expected a deprecated appliance ISO layout to be a LevelError finding")
+	}
+}
+
+func TestPreflightUpgradeIncompatibleExecutionEnvironment(t *testing.T) {
+	oldConfig := &config.VirtualContainerHostConfigSpec{ExecutionEnvironment: "vmware"}
+	newConfig := &data.InstallerData{ExecutionEnvironment: "docker"}
+
+	findings := PreflightUpgrade(oldConfig, currentConfigKeys(t), newConfig)
+
+	if !HasErrors(findings) {
+		t.Fatal("expected an incompatible network backing migration to be a LevelError finding")
+	}
+}
+
+func TestPreflightUpgradeCompatible(t *testing.T) {
+	oldConfig := &config.VirtualContainerHostConfigSpec{ExecutionEnvironment: "vmware"}
+	newConfig := &data.InstallerData{ExecutionEnvironment: "vmware"}
+
+	findings := PreflightUpgrade(oldConfig, currentConfigKeys(t), newConfig)
+
+	if HasErrors(findings) {
+		t.Fatalf("expected no errors for a compatible upgrade, got %v", findings)
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"io"
+)
+
+// MetadataEncryption is the reserved Image.Metadata key under which an
+// encrypted layer's envelope (algorithm, wrapped CEK, recipients) is
+// persisted alongside the image it protects. The key material itself is
+// never stored here - only what's needed to recover it given a matching
+// PrivateKey.
+const MetadataEncryption = "##encryption"
+
+// ErrNoMatchingKey is returned by LayerCrypter.Decrypt when none of the
+// supplied PrivateKeys can unwrap the layer's content-encryption key.
+var ErrNoMatchingKey = errors.New("storage: no private key matches this layer's recipients")
+
+// ErrDigestMismatch is returned when the digest of a layer's plaintext
+// doesn't match the sum supplied to WriteImage. The digest of the
+// ciphertext is not checked against sum.
+var ErrDigestMismatch = errors.New("storage: ciphertext digest does not match expected sum")
+
+// RecipientType identifies which wrapping scheme a Recipient/PrivateKey
+// pair uses to protect a layer's content-encryption key (CEK).
+type RecipientType string
+
+const (
+	// RecipientPGP wraps the CEK to an armored OpenPGP public key.
+	RecipientPGP RecipientType = "pgp"
+
+	// RecipientJWE wraps the CEK to an RSA public key with RSA-OAEP
+	// (SHA-256); the payload itself is sealed with AES-256-GCM. The
+	// wrapped CEK is kept as raw bytes, not as a compact JWE serialization.
+	RecipientJWE RecipientType = "jwe"
+)
+
+// Recipient identifies a party a layer should be encrypted to.
+type Recipient struct {
+	// Type selects which of the supported wrapping schemes Key is in.
+	Type RecipientType
+
+	// Key is the recipient's public key: an armored OpenPGP entity for
+	// RecipientPGP, or a DER-encoded PKIX RSA public key for RecipientJWE.
+	Key []byte
+}
+
+// PrivateKey identifies a party's private key, used to unwrap a CEK when
+// decrypting a layer previously sealed to the matching Recipient.
+type PrivateKey struct {
+	Type RecipientType
+
+	// Key is the recipient's private key: an armored OpenPGP entity for
+	// RecipientPGP, or a DER-encoded PKCS#1 RSA private key for
+	// RecipientJWE.
+	Key []byte
+}
+
+// WrappedKey is a single recipient's copy of a layer's content-encryption
+// key (CEK), wrapped so only that recipient's matching PrivateKey can
+// recover it.
+type WrappedKey struct {
+	Type RecipientType
+
+	// Recipient is an opaque identifier for the key this copy of the CEK
+	// is wrapped to (an OpenPGP key ID, or a SHA-256 fingerprint of the
+	// RSA public key for JWE). It is diagnostic only - Decrypt tries
+	// every WrappedKey against every supplied PrivateKey rather than
+	// matching on it.
+	Recipient string
+
+	// Key is the wrapped CEK.
+	Key []byte
+}
+
+// EncMetadata is the encryption envelope persisted under
+// MetadataEncryption in an encrypted Image's Metadata map.
+type EncMetadata struct {
+	// Algorithm identifies the symmetric cipher the layer payload was
+	// encrypted with, e.g. "aes-256-gcm".
+	Algorithm string
+
+	// WrappedKeys holds one wrapped copy of the CEK per recipient the
+	// layer was encrypted to.
+	WrappedKeys []WrappedKey
+}
+
+// LayerCrypter encrypts and decrypts layer payloads on their way into and
+// out of an ImageStorer, following the model used by containers/image's
+// copy/encryption.go: a random per-layer content-encryption key (CEK)
+// protects the actual payload, and the CEK itself is wrapped once per
+// Recipient so any one of their matching PrivateKeys can later recover it.
+//
+// The envelope a Decrypt needs to recover the CEK (algorithm, wrapped
+// keys) is carried in the ciphertext stream itself, so Decrypt needs
+// nothing beyond the ciphertext and a candidate set of keys; the
+// EncMetadata Encrypt returns alongside the stream is for callers that
+// want to persist or inspect it without decrypting (it is what
+// WriteImage stores under MetadataEncryption).
+//
+// Implementations must be safe for concurrent use.
+type LayerCrypter interface {
+	// Encrypt returns a reader over the encrypted form of r and the
+	// envelope describing how it was wrapped. The envelope is returned
+	// before the reader is necessarily drained, so it can be persisted
+	// alongside the layer while the ciphertext is still streaming.
+	Encrypt(r io.Reader, recipients []Recipient) (io.Reader, EncMetadata, error)
+
+	// Decrypt returns a reader over the decrypted form of r, which must
+	// be ciphertext previously produced by Encrypt. keys is searched for
+	// one able to unwrap the embedded CEK; ErrNoMatchingKey is returned
+	// if none match.
+	Decrypt(r io.Reader, keys []PrivateKey) (io.Reader, error)
+}
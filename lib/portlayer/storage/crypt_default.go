@@ -0,0 +1,192 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"golang.org/x/crypto/openpgp"
+)
+
+// defaultLayerCrypter is the stock LayerCrypter: it wraps a random
+// AES-256-GCM content-encryption key to each of a mix of PGP and JWE
+// (RSA-OAEP) recipients, and seals the layer payload under that key in
+// fixed-size chunks. See crypt_stream.go for the wire format.
+type defaultLayerCrypter struct{}
+
+// NewLayerCrypter returns the LayerCrypter used by ImageStorer
+// implementations that opt into layer encryption. It supports PGP and JWE
+// (RSA-OAEP + AES-GCM) recipients, and any mix of the two within a single
+// layer.
+func NewLayerCrypter() LayerCrypter {
+	return defaultLayerCrypter{}
+}
+
+func (defaultLayerCrypter) Encrypt(r io.Reader, recipients []Recipient) (io.Reader, EncMetadata, error) {
+	if len(recipients) == 0 {
+		return nil, EncMetadata{}, fmt.Errorf("storage: cannot encrypt a layer with no recipients")
+	}
+
+	cek, err := generateCEK()
+	if err != nil {
+		return nil, EncMetadata{}, err
+	}
+
+	wrapped := make([]WrappedKey, 0, len(recipients))
+	for _, recipient := range recipients {
+		wk, err := wrapCEK(cek, recipient)
+		if err != nil {
+			return nil, EncMetadata{}, err
+		}
+		wrapped = append(wrapped, wk)
+	}
+
+	stream, err := encryptStream(cek, wrapped, r)
+	if err != nil {
+		return nil, EncMetadata{}, err
+	}
+
+	return stream, EncMetadata{Algorithm: algorithmAES256GCM, WrappedKeys: wrapped}, nil
+}
+
+func (defaultLayerCrypter) Decrypt(r io.Reader, keys []PrivateKey) (io.Reader, error) {
+	if len(keys) == 0 {
+		return nil, ErrNoMatchingKey
+	}
+	return decryptStream(r, keys)
+}
+
+// wrapCEK seals cek to recipient, returning the WrappedKey to persist in
+// the layer's EncMetadata.
+func wrapCEK(cek []byte, recipient Recipient) (WrappedKey, error) {
+	switch recipient.Type {
+	case RecipientPGP:
+		return wrapCEKPGP(cek, recipient.Key)
+	case RecipientJWE:
+		return wrapCEKJWE(cek, recipient.Key)
+	default:
+		return WrappedKey{}, fmt.Errorf("storage: unsupported recipient type %q", recipient.Type)
+	}
+}
+
+// unwrapCEK tries every combination of wrapped and keys of matching type
+// until one succeeds, returning ErrNoMatchingKey if none do.
+func unwrapCEK(wrapped []WrappedKey, keys []PrivateKey) ([]byte, error) {
+	for _, wk := range wrapped {
+		for _, key := range keys {
+			if key.Type != wk.Type {
+				continue
+			}
+
+			var (
+				cek []byte
+				err error
+			)
+			switch wk.Type {
+			case RecipientPGP:
+				cek, err = unwrapCEKPGP(wk.Key, key.Key)
+			case RecipientJWE:
+				cek, err = unwrapCEKJWE(wk.Key, key.Key)
+			default:
+				continue
+			}
+			if err == nil {
+				return cek, nil
+			}
+		}
+	}
+	return nil, ErrNoMatchingKey
+}
+
+func wrapCEKPGP(cek, armoredPublicKey []byte) (WrappedKey, error) {
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(armoredPublicKey))
+	if err != nil {
+		return WrappedKey{}, fmt.Errorf("storage: parsing PGP recipient: %v", err)
+	}
+	if len(keyring) == 0 {
+		return WrappedKey{}, fmt.Errorf("storage: PGP recipient key ring is empty")
+	}
+	entity := keyring[0]
+
+	buf := &bytes.Buffer{}
+	w, err := openpgp.Encrypt(buf, []*openpgp.Entity{entity}, nil, nil, nil)
+	if err != nil {
+		return WrappedKey{}, fmt.Errorf("storage: wrapping CEK to PGP recipient: %v", err)
+	}
+	if _, err := w.Write(cek); err != nil {
+		return WrappedKey{}, err
+	}
+	if err := w.Close(); err != nil {
+		return WrappedKey{}, err
+	}
+
+	return WrappedKey{
+		Type:      RecipientPGP,
+		Recipient: entity.PrimaryKey.KeyIdString(),
+		Key:       buf.Bytes(),
+	}, nil
+}
+
+func unwrapCEKPGP(wrappedCEK, armoredPrivateKey []byte) ([]byte, error) {
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(armoredPrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("storage: parsing PGP private key: %v", err)
+	}
+
+	msg, err := openpgp.ReadMessage(bytes.NewReader(wrappedCEK), keyring, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(msg.UnverifiedBody)
+}
+
+func wrapCEKJWE(cek, derPublicKey []byte) (WrappedKey, error) {
+	pub, err := x509.ParsePKIXPublicKey(derPublicKey)
+	if err != nil {
+		return WrappedKey{}, fmt.Errorf("storage: parsing JWE recipient: %v", err)
+	}
+	rsaPub, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return WrappedKey{}, fmt.Errorf("storage: JWE recipient must be an RSA public key")
+	}
+
+	wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, rsaPub, cek, nil)
+	if err != nil {
+		return WrappedKey{}, fmt.Errorf("storage: wrapping CEK to JWE recipient: %v", err)
+	}
+
+	fingerprint := sha256.Sum256(derPublicKey)
+	return WrappedKey{
+		Type:      RecipientJWE,
+		Recipient: hex.EncodeToString(fingerprint[:]),
+		Key:       wrapped,
+	}, nil
+}
+
+func unwrapCEKJWE(wrappedCEK, derPrivateKey []byte) ([]byte, error) {
+	priv, err := x509.ParsePKCS1PrivateKey(derPrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("storage: parsing JWE private key: %v", err)
+	}
+	return rsa.DecryptOAEP(sha256.New(), rand.Reader, priv, wrappedCEK, nil)
+}
@@ -0,0 +1,216 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// algorithmAES256GCM is the only symmetric algorithm defaultLayerCrypter produces
+// or accepts today. It's recorded in EncMetadata so a future algorithm
+// change can be detected on read rather than silently misinterpreted.
+const algorithmAES256GCM = "aes-256-gcm"
+
+// streamChunkSize is the amount of plaintext sealed under a single AES-GCM
+// nonce. Chunking keeps memory use bounded for VMDK-sized layers while
+// still using an AEAD whose Go implementation requires a full chunk in
+// memory to seal or open.
+const streamChunkSize = 1 << 20 // 1MiB
+
+// cekSize is the length in bytes of a layer's content-encryption key, 256
+// bits for algorithmAES256GCM.
+const cekSize = 32
+
+func generateCEK() ([]byte, error) {
+	cek := make([]byte, cekSize)
+	if _, err := io.ReadFull(rand.Reader, cek); err != nil {
+		return nil, fmt.Errorf("storage: generating content-encryption key: %v", err)
+	}
+	return cek, nil
+}
+
+// streamHeader is the wire format written ahead of the ciphertext by
+// encryptStream and read back by decryptStream. It carries everything
+// Decrypt needs to recover the CEK and authenticate the payload without a
+// separate EncMetadata argument.
+type streamHeader struct {
+	Algorithm   string
+	WrappedKeys []WrappedKey
+	BaseNonce   []byte
+}
+
+// encryptStream wraps r with a header describing how cek is wrapped for
+// each recipient, followed by cek-encrypted chunks of r. It returns
+// immediately; encryption happens as the returned reader is drained.
+func encryptStream(cek []byte, wrapped []WrappedKey, r io.Reader) (io.Reader, error) {
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	baseNonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, baseNonce); err != nil {
+		return nil, fmt.Errorf("storage: generating nonce: %v", err)
+	}
+
+	header, err := json.Marshal(streamHeader{
+		Algorithm:   algorithmAES256GCM,
+		WrappedKeys: wrapped,
+		BaseNonce:   baseNonce,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(runEncryptStream(pw, header, gcm, baseNonce, r))
+	}()
+	return pr, nil
+}
+
+func runEncryptStream(w io.Writer, header []byte, gcm cipher.AEAD, baseNonce []byte, r io.Reader) error {
+	if err := writeChunk(w, header); err != nil {
+		return err
+	}
+
+	buf := make([]byte, streamChunkSize)
+	nonce := make([]byte, len(baseNonce))
+	var counter uint64
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			chunkNonce(nonce, baseNonce, counter)
+			counter++
+			sealed := gcm.Seal(nil, nonce, buf[:n], nil)
+			if werr := writeChunk(w, sealed); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// decryptStream reads back the header written by encryptStream, unwraps
+// the CEK with unwrapCEK (the first of the header's WrappedKeys that any of
+// keys can unwrap wins), and returns a reader over the decrypted chunks.
+func decryptStream(r io.Reader, keys []PrivateKey) (io.Reader, error) {
+	headerBytes, err := readChunk(r)
+	if err != nil {
+		return nil, fmt.Errorf("storage: reading encryption header: %v", err)
+	}
+
+	var header streamHeader
+	if err := json.Unmarshal(headerBytes, &header); err != nil {
+		return nil, fmt.Errorf("storage: parsing encryption header: %v", err)
+	}
+	if header.Algorithm != algorithmAES256GCM {
+		return nil, fmt.Errorf("storage: unsupported layer encryption algorithm %q", header.Algorithm)
+	}
+
+	cek, err := unwrapCEK(header.WrappedKeys, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(runDecryptStream(pw, gcm, header.BaseNonce, r))
+	}()
+	return pr, nil
+}
+
+func runDecryptStream(w io.Writer, gcm cipher.AEAD, baseNonce []byte, r io.Reader) error {
+	nonce := make([]byte, len(baseNonce))
+	var counter uint64
+	for {
+		sealed, err := readChunk(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		chunkNonce(nonce, baseNonce, counter)
+		counter++
+		plain, err := gcm.Open(nil, nonce, sealed, nil)
+		if err != nil {
+			return fmt.Errorf("storage: decrypting layer chunk %d: %v", counter-1, err)
+		}
+		if _, err := w.Write(plain); err != nil {
+			return err
+		}
+	}
+}
+
+// chunkNonce derives the nonce for chunk counter by XORing it into the
+// low 8 bytes of baseNonce, so every chunk in a stream uses a distinct
+// nonce under the same CEK without needing to persist one per chunk.
+func chunkNonce(dst, baseNonce []byte, counter uint64) {
+	copy(dst, baseNonce)
+	var ctr [8]byte
+	binary.BigEndian.PutUint64(ctr[:], counter)
+	off := len(dst) - len(ctr)
+	for i := range ctr {
+		dst[off+i] ^= ctr[i]
+	}
+}
+
+func writeChunk(w io.Writer, b []byte) error {
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(b)))
+	if _, err := w.Write(length[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+func readChunk(r io.Reader) ([]byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, binary.BigEndian.Uint32(length[:]))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
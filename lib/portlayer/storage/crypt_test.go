@@ -0,0 +1,158 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"io/ioutil"
+	"testing"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+func generateJWERecipient(t *testing.T) (Recipient, PrivateKey) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pub, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return Recipient{Type: RecipientJWE, Key: pub}, PrivateKey{Type: RecipientJWE, Key: x509.MarshalPKCS1PrivateKey(priv)}
+}
+
+func generatePGPRecipient(t *testing.T) (Recipient, PrivateKey) {
+	entity, err := openpgp.NewEntity("vic test", "", "vic-test@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pubBuf := &bytes.Buffer{}
+	pubArmor, err := armor.Encode(pubBuf, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.Serialize(pubArmor); err != nil {
+		t.Fatal(err)
+	}
+	if err := pubArmor.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	privBuf := &bytes.Buffer{}
+	privArmor, err := armor.Encode(privBuf, openpgp.PrivateKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.SerializePrivate(privArmor, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := privArmor.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return Recipient{Type: RecipientPGP, Key: pubBuf.Bytes()}, PrivateKey{Type: RecipientPGP, Key: privBuf.Bytes()}
+}
+
+func TestLayerCrypterRoundTrip(t *testing.T) {
+	recipient, key := generateJWERecipient(t)
+	crypter := NewLayerCrypter()
+
+	plaintext := bytes.Repeat([]byte("vic layer content "), 1<<16)
+
+	ciphertext, meta, err := crypter.Encrypt(bytes.NewReader(plaintext), []Recipient{recipient})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Algorithm != algorithmAES256GCM {
+		t.Fatalf("expected algorithm %q, got %q", algorithmAES256GCM, meta.Algorithm)
+	}
+	if len(meta.WrappedKeys) != 1 {
+		t.Fatalf("expected 1 wrapped key, got %d", len(meta.WrappedKeys))
+	}
+
+	decrypted, err := crypter.Decrypt(ciphertext, []PrivateKey{key})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ioutil.ReadAll(decrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Fatal("decrypted content does not match plaintext")
+	}
+}
+
+func TestLayerCrypterRoundTripPGP(t *testing.T) {
+	recipient, key := generatePGPRecipient(t)
+	crypter := NewLayerCrypter()
+
+	plaintext := bytes.Repeat([]byte("vic layer content "), 1<<16)
+
+	ciphertext, meta, err := crypter.Encrypt(bytes.NewReader(plaintext), []Recipient{recipient})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Algorithm != algorithmAES256GCM {
+		t.Fatalf("expected algorithm %q, got %q", algorithmAES256GCM, meta.Algorithm)
+	}
+	if len(meta.WrappedKeys) != 1 {
+		t.Fatalf("expected 1 wrapped key, got %d", len(meta.WrappedKeys))
+	}
+
+	decrypted, err := crypter.Decrypt(ciphertext, []PrivateKey{key})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ioutil.ReadAll(decrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Fatal("decrypted content does not match plaintext")
+	}
+}
+
+func TestLayerCrypterDecryptNoMatchingKey(t *testing.T) {
+	recipient, _ := generateJWERecipient(t)
+	_, otherKey := generateJWERecipient(t)
+	crypter := NewLayerCrypter()
+
+	ciphertext, _, err := crypter.Encrypt(bytes.NewReader([]byte("hello")), []Recipient{recipient})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := crypter.Decrypt(ciphertext, []PrivateKey{otherKey}); err != ErrNoMatchingKey {
+		t.Fatalf("expected ErrNoMatchingKey, got %v", err)
+	}
+}
+
+func TestLayerCrypterEncryptRequiresRecipients(t *testing.T) {
+	crypter := NewLayerCrypter()
+	if _, _, err := crypter.Encrypt(bytes.NewReader([]byte("hello")), nil); err == nil {
+		t.Fatal("expected an error encrypting with no recipients")
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// encryptingImageStorer decorates an ImageStorer so that every WriteImage
+// encrypts its payload with crypter before handing it to the underlying
+// store, and every ReadImage transparently decrypts layers that carry a
+// MetadataEncryption envelope.
+type encryptingImageStorer struct {
+	ImageStorer
+
+	crypter    LayerCrypter
+	recipients []Recipient
+}
+
+// NewEncryptingImageStorer wraps store so that WriteImage checks sum
+// against the digest of the plaintext layer content and encrypts that
+// content to recipients using crypter before it's persisted, and ReadImage transparently decrypts layers written this way. Images
+// written through store directly (or by another process) that don't
+// carry a MetadataEncryption envelope are passed through ReadImage
+// unchanged.
+func NewEncryptingImageStorer(store ImageStorer, crypter LayerCrypter, recipients []Recipient) ImageStorer {
+	return &encryptingImageStorer{
+		ImageStorer: store,
+		crypter:     crypter,
+		recipients:  recipients,
+	}
+}
+
+func (e *encryptingImageStorer) WriteImage(ctx context.Context, parent *Image, ID string, meta map[string][]byte, sum string, r io.Reader) (*Image, error) {
+	// sum is a digest of the plaintext (e.g. from a registry manifest), so
+	// it has to be hashed and checked against the plaintext r, not the
+	// ciphertext Encrypt produces: Encrypt generates a fresh random CEK
+	// and nonce every call, so the ciphertext - and its digest - differs
+	// on every write even for identical plaintext and recipients.
+	digest := sha256.New()
+	checked := io.TeeReader(r, digest)
+
+	ciphertext, envelope, err := e.crypter.Encrypt(checked, e.recipients)
+	if err != nil {
+		return nil, fmt.Errorf("storage: encrypting layer %s: %v", ID, err)
+	}
+
+	envelopeJSON, err := json.Marshal(envelope)
+	if err != nil {
+		return nil, err
+	}
+
+	if meta == nil {
+		meta = make(map[string][]byte)
+	}
+	meta[MetadataEncryption] = envelopeJSON
+
+	// Stream ciphertext straight into the underlying store instead of
+	// buffering the whole layer in memory first - layers are VMDK-sized,
+	// and crypt_stream.go's whole chunked design exists to keep memory use
+	// bounded. The plaintext digest can only be checked once the
+	// underlying store has read ciphertext to EOF, driving checked to EOF
+	// in turn, so the sum check has to happen after this call returns
+	// rather than before it. ImageStorer has no delete, so a mismatch
+	// here leaves a bad layer already persisted; that's reported back as
+	// an error for the caller to deal with rather than papered over.
+	image, err := e.ImageStorer.WriteImage(ctx, parent, ID, meta, sum, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	if sum != "" && !strings.EqualFold(sum, hex.EncodeToString(digest.Sum(nil))) {
+		return nil, ErrDigestMismatch
+	}
+
+	return image, nil
+}
+
+func (e *encryptingImageStorer) ReadImage(ctx context.Context, image *Image, keys []PrivateKey) (io.ReadCloser, error) {
+	envelopeJSON, ok := image.Metadata[MetadataEncryption]
+	if !ok {
+		return e.ImageStorer.ReadImage(ctx, image, keys)
+	}
+
+	var envelope EncMetadata
+	if err := json.Unmarshal(envelopeJSON, &envelope); err != nil {
+		return nil, fmt.Errorf("storage: parsing encryption envelope for %s: %v", image.ID, err)
+	}
+	if len(keys) == 0 {
+		return nil, ErrNoMatchingKey
+	}
+
+	rc, err := e.ImageStorer.ReadImage(ctx, image, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := e.crypter.Decrypt(rc, keys)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+
+	return &decryptReadCloser{Reader: plaintext, underlying: rc}, nil
+}
+
+// decryptReadCloser closes the underlying ciphertext stream once the
+// caller is done reading the decrypted one layered on top of it.
+type decryptReadCloser struct {
+	io.Reader
+	underlying io.ReadCloser
+}
+
+func (d *decryptReadCloser) Close() error {
+	return d.underlying.Close()
+}
@@ -0,0 +1,153 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// memoryImageStorer is a minimal in-memory ImageStorer used to exercise
+// encryptingImageStorer without a real backing store.
+type memoryImageStorer struct {
+	images map[string]*Image
+	data   map[string][]byte
+}
+
+func newMemoryImageStorer() *memoryImageStorer {
+	return &memoryImageStorer{images: make(map[string]*Image), data: make(map[string][]byte)}
+}
+
+func (m *memoryImageStorer) CreateImageStore(ctx context.Context, storeName string) (*url.URL, error) {
+	return nil, nil
+}
+func (m *memoryImageStorer) GetImageStore(ctx context.Context, storeName string) (*url.URL, error) {
+	return nil, nil
+}
+func (m *memoryImageStorer) ListImageStores(ctx context.Context) ([]*url.URL, error) { return nil, nil }
+
+func (m *memoryImageStorer) WriteImage(ctx context.Context, parent *Image, ID string, meta map[string][]byte, sum string, r io.Reader) (*Image, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	image := &Image{ID: ID, Metadata: meta}
+	m.images[ID] = image
+	m.data[ID] = b
+	return image, nil
+}
+
+func (m *memoryImageStorer) ReadImage(ctx context.Context, image *Image, keys []PrivateKey) (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader(m.data[image.ID])), nil
+}
+
+func (m *memoryImageStorer) GetImage(ctx context.Context, store *url.URL, ID string) (*Image, error) {
+	return m.images[ID], nil
+}
+
+func (m *memoryImageStorer) ListImages(ctx context.Context, store *url.URL, IDs []string) ([]*Image, error) {
+	return nil, nil
+}
+
+func TestEncryptingImageStorerRoundTrip(t *testing.T) {
+	recipient, key := generateJWERecipient(t)
+	backing := newMemoryImageStorer()
+	store := NewEncryptingImageStorer(backing, NewLayerCrypter(), []Recipient{recipient})
+
+	plaintext := []byte("vic layer content")
+
+	image, err := store.WriteImage(context.Background(), nil, "layer1", nil, "", bytes.NewReader(plaintext))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := image.Metadata[MetadataEncryption]; !ok {
+		t.Fatal("expected MetadataEncryption to be set on the written image")
+	}
+
+	rc, err := store.ReadImage(context.Background(), image, []PrivateKey{key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	got, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Fatalf("expected %q, got %q", plaintext, got)
+	}
+}
+
+func TestEncryptingImageStorerWriteImageChecksPlaintextDigest(t *testing.T) {
+	recipient, key := generateJWERecipient(t)
+	backing := newMemoryImageStorer()
+	store := NewEncryptingImageStorer(backing, NewLayerCrypter(), []Recipient{recipient})
+
+	plaintext := []byte("vic layer content")
+	sum := sha256.Sum256(plaintext)
+	expected := hex.EncodeToString(sum[:])
+
+	image, err := store.WriteImage(context.Background(), nil, "layer1", nil, expected, bytes.NewReader(plaintext))
+	if err != nil {
+		t.Fatalf("expected a matching plaintext digest to succeed, got %v", err)
+	}
+
+	rc, err := store.ReadImage(context.Background(), image, []PrivateKey{key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	got, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Fatalf("expected %q, got %q", plaintext, got)
+	}
+}
+
+func TestEncryptingImageStorerWriteImageDigestMismatch(t *testing.T) {
+	recipient, _ := generateJWERecipient(t)
+	backing := newMemoryImageStorer()
+	store := NewEncryptingImageStorer(backing, NewLayerCrypter(), []Recipient{recipient})
+
+	if _, err := store.WriteImage(context.Background(), nil, "layer1", nil, "sha256:deadbeef", bytes.NewReader([]byte("vic layer content"))); err != ErrDigestMismatch {
+		t.Fatalf("expected ErrDigestMismatch, got %v", err)
+	}
+}
+
+func TestEncryptingImageStorerReadRequiresKey(t *testing.T) {
+	recipient, _ := generateJWERecipient(t)
+	backing := newMemoryImageStorer()
+	store := NewEncryptingImageStorer(backing, NewLayerCrypter(), []Recipient{recipient})
+
+	image, err := store.WriteImage(context.Background(), nil, "layer1", nil, "", bytes.NewReader([]byte("secret")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.ReadImage(context.Background(), image, nil); err != ErrNoMatchingKey {
+		t.Fatalf("expected ErrNoMatchingKey, got %v", err)
+	}
+}
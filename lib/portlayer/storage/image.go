@@ -53,8 +53,21 @@ type ImageStorer interface {
 	// meta - metadata associated with the image
 	// sum - expected sha266 sum of the image content.
 	// r - the image tar to be written
+	//
+	// If the ImageStorer was built with a LayerCrypter (see
+	// NewEncryptingImageStorer), sum is checked against the digest of the
+	// plaintext r, r is encrypted before it is persisted, and the resulting
+	// envelope is stored under MetadataEncryption in the returned Image's
+	// Metadata.
 	WriteImage(ctx context.Context, parent *Image, ID string, meta map[string][]byte, sum string, r io.Reader) (*Image, error)
 
+	// ReadImage returns the content of image, decrypting it first if it
+	// carries a MetadataEncryption envelope. keys is searched for a
+	// PrivateKey able to unwrap the layer's content-encryption key;
+	// ErrNoMatchingKey is returned if image is encrypted and none match.
+	// Callers must Close the returned ReadCloser.
+	ReadImage(ctx context.Context, image *Image, keys []PrivateKey) (io.ReadCloser, error)
+
 	// GetImage queries the image store for the specified image.
 	//
 	// store - The image store to query name - The name of the image (optional)
@@ -85,6 +98,14 @@ type Image struct {
 	// Store is the URL for the image store the image can be found on.
 	Store *url.URL
 
+	// MediaType is the normalized (see NormalizeMediaType) media type of
+	// the layer's manifest/descriptor, e.g.
+	// "application/vnd.docker.distribution.manifest.v2+json" or
+	// "application/vnd.oci.image.manifest.v1+json". It is also persisted
+	// under MetadataMediaType in Metadata so it survives a round trip
+	// through stores that don't know about this field.
+	MediaType string
+
 	// Metadata associated with the image.
 	Metadata map[string][]byte
 }
@@ -104,6 +125,7 @@ func (i *Image) Copy() index.Element {
 		SelfLink:   selflink,
 		ParentLink: parent,
 		Store:      store,
+		MediaType:  i.MediaType,
 	}
 
 	if i.Metadata != nil {
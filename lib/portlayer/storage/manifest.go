@@ -0,0 +1,208 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"golang.org/x/net/context"
+)
+
+// MetadataMediaType is the reserved Image.Metadata key a layer's
+// normalized media type is persisted under, so it survives a round trip
+// through stores that predate the MediaType field.
+const MetadataMediaType = "##mediatype"
+
+// MetadataManifest is the reserved Image.Metadata key a layer's raw
+// manifest JSON is persisted under. ConvertImage rewrites this alongside
+// MediaType/MetadataMediaType when converting schemas.
+const MetadataManifest = "##manifest"
+
+// The manifest media types ManifestConverter knows how to normalize to
+// and convert between. Legacy/ambiguous values such as "text/plain" or ""
+// normalize to MediaTypeDockerManifestSchema1, matching what older
+// registries actually served for unsigned schema1 manifests.
+const (
+	MediaTypeDockerManifestSchema1 = "application/vnd.docker.distribution.manifest.v1+json"
+	MediaTypeDockerManifestSchema2 = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeOCIManifest           = "application/vnd.oci.image.manifest.v1+json"
+
+	mediaTypeDockerConfig = "application/vnd.docker.container.image.v1+json"
+	mediaTypeDockerLayer  = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+	mediaTypeOCIConfig    = "application/vnd.oci.image.config.v1+json"
+	mediaTypeOCILayer     = "application/vnd.oci.image.layer.v1.tar+gzip"
+)
+
+// legacyMediaTypes maps values real-world registries have been observed to
+// send for a schema1 manifest - usually because they predate media type
+// negotiation entirely - to the canonical type they actually mean.
+var legacyMediaTypes = map[string]string{
+	"":                 MediaTypeDockerManifestSchema1,
+	"text/plain":       MediaTypeDockerManifestSchema1,
+	"application/json": MediaTypeDockerManifestSchema1,
+}
+
+// manifestTypePriority orders the types ManifestConverter supports from
+// most to least preferred, for PickManifestType's fallback search.
+var manifestTypePriority = []string{
+	MediaTypeOCIManifest,
+	MediaTypeDockerManifestSchema2,
+	MediaTypeDockerManifestSchema1,
+}
+
+// NormalizeMediaType rewrites weird or legacy manifest media type values
+// to the canonical Docker or OCI type they actually mean, matching
+// containers/image's manifest.NormalizedMIMEType. Already-canonical
+// values are returned unchanged.
+func NormalizeMediaType(raw string) string {
+	if canonical, ok := legacyMediaTypes[raw]; ok {
+		return canonical
+	}
+	return raw
+}
+
+// PickManifestType returns src, normalized, if destSupported accepts it,
+// and otherwise the highest-priority type in destSupported, matching the
+// negotiation containers/image's copy code performs against a
+// destination's SupportedManifestMIMETypes. An error is returned if
+// destSupported accepts neither src nor any of the types this package
+// knows how to produce.
+func PickManifestType(src string, destSupported []string) (string, error) {
+	normalizedSrc := NormalizeMediaType(src)
+
+	supported := make(map[string]bool, len(destSupported))
+	for _, t := range destSupported {
+		supported[NormalizeMediaType(t)] = true
+	}
+
+	if supported[normalizedSrc] {
+		return normalizedSrc, nil
+	}
+
+	for _, t := range manifestTypePriority {
+		if supported[t] {
+			return t, nil
+		}
+	}
+
+	return "", fmt.Errorf("storage: none of %v is a manifest type this image store can produce", destSupported)
+}
+
+// manifestDescriptor is the config/layer descriptor shape shared by
+// Docker schema2 and OCI v1 manifests.
+type manifestDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+// schema2Manifest is the structurally-compatible subset of Docker
+// schema2 and OCI v1 manifests; only the media type values differ
+// between the two schemas, never the shape.
+type schema2Manifest struct {
+	SchemaVersion int                  `json:"schemaVersion"`
+	MediaType     string               `json:"mediaType"`
+	Config        manifestDescriptor   `json:"config"`
+	Layers        []manifestDescriptor `json:"layers"`
+}
+
+// ManifestConverter rewrites a layer's manifest and descriptor media
+// types when the destination it's being written to only supports a
+// subset of the types this image store can produce.
+type ManifestConverter struct{}
+
+// NewManifestConverter returns a ManifestConverter.
+func NewManifestConverter() *ManifestConverter {
+	return &ManifestConverter{}
+}
+
+// ConvertImage rewrites image's manifest and descriptor media types to
+// targetType, returning a new *Image that leaves image untouched. Only
+// conversion between MediaTypeDockerManifestSchema2 and
+// MediaTypeOCIManifest is supported today - schema1 has no config/layers
+// descriptors to rewrite and must be re-fetched from the source registry
+// in that format instead. ConvertImage is a no-op, returning image as-is,
+// if image is already in targetType.
+func (c *ManifestConverter) ConvertImage(ctx context.Context, image *Image, targetType string) (*Image, error) {
+	target := NormalizeMediaType(targetType)
+	current := image.MediaType
+	if current == "" {
+		current = NormalizeMediaType(string(image.Metadata[MetadataMediaType]))
+	}
+
+	if current == target {
+		return image, nil
+	}
+
+	if !isSchema2Family(current) || !isSchema2Family(target) {
+		return nil, fmt.Errorf("storage: converting a layer from %q to %q is not supported", current, target)
+	}
+
+	raw, ok := image.Metadata[MetadataManifest]
+	if !ok {
+		return nil, fmt.Errorf("storage: image %s has no manifest to convert", image.ID)
+	}
+
+	var m schema2Manifest
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return nil, fmt.Errorf("storage: parsing manifest for %s: %v", image.ID, err)
+	}
+
+	m.MediaType = target
+	m.Config.MediaType = convertDescriptorMediaType(m.Config.MediaType, target)
+	for i := range m.Layers {
+		m.Layers[i].MediaType = convertDescriptorMediaType(m.Layers[i].MediaType, target)
+	}
+
+	converted, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+
+	result, ok := image.Copy().(*Image)
+	if !ok {
+		return nil, fmt.Errorf("storage: unexpected type %T copying image %s", image.Copy(), image.ID)
+	}
+	result.MediaType = target
+	if result.Metadata == nil {
+		result.Metadata = make(map[string][]byte)
+	}
+	result.Metadata[MetadataMediaType] = []byte(target)
+	result.Metadata[MetadataManifest] = converted
+
+	return result, nil
+}
+
+func isSchema2Family(mediaType string) bool {
+	return mediaType == MediaTypeDockerManifestSchema2 || mediaType == MediaTypeOCIManifest
+}
+
+// convertDescriptorMediaType rewrites a single config or layer descriptor
+// media type to the family matching targetManifestType.
+func convertDescriptorMediaType(mediaType, targetManifestType string) string {
+	switch {
+	case mediaType == mediaTypeDockerConfig && targetManifestType == MediaTypeOCIManifest:
+		return mediaTypeOCIConfig
+	case mediaType == mediaTypeOCIConfig && targetManifestType == MediaTypeDockerManifestSchema2:
+		return mediaTypeDockerConfig
+	case mediaType == mediaTypeDockerLayer && targetManifestType == MediaTypeOCIManifest:
+		return mediaTypeOCILayer
+	case mediaType == mediaTypeOCILayer && targetManifestType == MediaTypeDockerManifestSchema2:
+		return mediaTypeDockerLayer
+	default:
+		return mediaType
+	}
+}
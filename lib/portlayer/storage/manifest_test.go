@@ -0,0 +1,137 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"encoding/json"
+	"net/url"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func testImageURLs() (*url.URL, *url.URL) {
+	selfLink, _ := url.Parse("/storage/store/layer1")
+	store, _ := url.Parse("/storage/store")
+	return selfLink, store
+}
+
+func TestNormalizeMediaType(t *testing.T) {
+	tests := map[string]string{
+		"":                             MediaTypeDockerManifestSchema1,
+		"text/plain":                   MediaTypeDockerManifestSchema1,
+		"application/json":             MediaTypeDockerManifestSchema1,
+		MediaTypeDockerManifestSchema2: MediaTypeDockerManifestSchema2,
+		MediaTypeOCIManifest:           MediaTypeOCIManifest,
+	}
+
+	for raw, want := range tests {
+		if got := NormalizeMediaType(raw); got != want {
+			t.Errorf("NormalizeMediaType(%q) = %q, want %q", raw, got, want)
+		}
+	}
+}
+
+func TestPickManifestType(t *testing.T) {
+	dockerOnly := []string{MediaTypeDockerManifestSchema2}
+
+	got, err := PickManifestType(MediaTypeDockerManifestSchema2, dockerOnly)
+	if err != nil || got != MediaTypeDockerManifestSchema2 {
+		t.Fatalf("expected src returned unchanged, got %q, %v", got, err)
+	}
+
+	got, err = PickManifestType(MediaTypeOCIManifest, dockerOnly)
+	if err != nil || got != MediaTypeDockerManifestSchema2 {
+		t.Fatalf("expected fallback to %q, got %q, %v", MediaTypeDockerManifestSchema2, got, err)
+	}
+
+	if _, err := PickManifestType(MediaTypeOCIManifest, []string{"bogus"}); err == nil {
+		t.Fatal("expected an error when destSupported has nothing this package can produce")
+	}
+}
+
+func TestManifestConverterOCIToDockerSchema2(t *testing.T) {
+	manifest := schema2Manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeOCIManifest,
+		Config:        manifestDescriptor{MediaType: mediaTypeOCIConfig, Digest: "sha256:config", Size: 100},
+		Layers: []manifestDescriptor{
+			{MediaType: mediaTypeOCILayer, Digest: "sha256:layer1", Size: 200},
+		},
+	}
+	raw, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	selfLink, store := testImageURLs()
+	image := &Image{
+		ID:        "layer1",
+		SelfLink:  selfLink,
+		Store:     store,
+		MediaType: MediaTypeOCIManifest,
+		Metadata: map[string][]byte{
+			MetadataMediaType: []byte(MediaTypeOCIManifest),
+			MetadataManifest:  raw,
+		},
+	}
+
+	converter := NewManifestConverter()
+	converted, err := converter.ConvertImage(context.Background(), image, MediaTypeDockerManifestSchema2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if converted.MediaType != MediaTypeDockerManifestSchema2 {
+		t.Fatalf("expected MediaType %q, got %q", MediaTypeDockerManifestSchema2, converted.MediaType)
+	}
+
+	var out schema2Manifest
+	if err := json.Unmarshal(converted.Metadata[MetadataManifest], &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Config.MediaType != mediaTypeDockerConfig {
+		t.Fatalf("expected config mediaType %q, got %q", mediaTypeDockerConfig, out.Config.MediaType)
+	}
+	if out.Layers[0].MediaType != mediaTypeDockerLayer {
+		t.Fatalf("expected layer mediaType %q, got %q", mediaTypeDockerLayer, out.Layers[0].MediaType)
+	}
+
+	if image.MediaType != MediaTypeOCIManifest {
+		t.Fatal("ConvertImage must not mutate the source image")
+	}
+}
+
+func TestManifestConverterNoop(t *testing.T) {
+	image := &Image{ID: "layer1", MediaType: MediaTypeDockerManifestSchema2}
+	converter := NewManifestConverter()
+
+	converted, err := converter.ConvertImage(context.Background(), image, MediaTypeDockerManifestSchema2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if converted != image {
+		t.Fatal("expected ConvertImage to return the same image when already in the target type")
+	}
+}
+
+func TestManifestConverterUnsupportedSchema1(t *testing.T) {
+	image := &Image{ID: "layer1", MediaType: MediaTypeDockerManifestSchema1}
+	converter := NewManifestConverter()
+
+	if _, err := converter.ConvertImage(context.Background(), image, MediaTypeOCIManifest); err == nil {
+		t.Fatal("expected an error converting from schema1")
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"io"
+	"net/url"
+
+	"golang.org/x/net/context"
+)
+
+// normalizingImageStorer decorates an ImageStorer so that every WriteImage
+// normalizes the caller-supplied media type (see NormalizeMediaType)
+// before it's persisted, and every Image it returns whose Metadata carries
+// MetadataMediaType has MediaType populated - including ones that
+// round-tripped through a store that predates the field. An Image with
+// neither keeps an empty MediaType.
+type normalizingImageStorer struct {
+	ImageStorer
+}
+
+// NewMediaTypeNormalizingImageStorer wraps store so that callers don't
+// need to normalize media types themselves before calling WriteImage, and
+// so that tools copying layers into a VCH from a v2 registry don't have
+// to reject anything that isn't the exact media type they expect.
+func NewMediaTypeNormalizingImageStorer(store ImageStorer) ImageStorer {
+	return &normalizingImageStorer{ImageStorer: store}
+}
+
+func (n *normalizingImageStorer) WriteImage(ctx context.Context, parent *Image, ID string, meta map[string][]byte, sum string, r io.Reader) (*Image, error) {
+	if meta == nil {
+		meta = make(map[string][]byte)
+	}
+
+	normalized := NormalizeMediaType(string(meta[MetadataMediaType]))
+	meta[MetadataMediaType] = []byte(normalized)
+
+	image, err := n.ImageStorer.WriteImage(ctx, parent, ID, meta, sum, r)
+	if err != nil {
+		return nil, err
+	}
+	image.MediaType = normalized
+	return image, nil
+}
+
+func (n *normalizingImageStorer) GetImage(ctx context.Context, store *url.URL, ID string) (*Image, error) {
+	image, err := n.ImageStorer.GetImage(ctx, store, ID)
+	if err != nil {
+		return nil, err
+	}
+	backfillMediaType(image)
+	return image, nil
+}
+
+func (n *normalizingImageStorer) ListImages(ctx context.Context, store *url.URL, IDs []string) ([]*Image, error) {
+	images, err := n.ImageStorer.ListImages(ctx, store, IDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, image := range images {
+		backfillMediaType(image)
+	}
+	return images, nil
+}
+
+// backfillMediaType fills in MediaType from the persisted
+// MetadataMediaType entry for images that were written by a store, or a
+// version of this store, that predates the MediaType field.
+func backfillMediaType(image *Image) {
+	if image == nil || image.MediaType != "" {
+		return
+	}
+	if raw, ok := image.Metadata[MetadataMediaType]; ok {
+		image.MediaType = NormalizeMediaType(string(raw))
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestNormalizingImageStorerWriteImageNormalizesMediaType(t *testing.T) {
+	backing := newMemoryImageStorer()
+	store := NewMediaTypeNormalizingImageStorer(backing)
+
+	meta := map[string][]byte{MetadataMediaType: []byte("text/plain")}
+	image, err := store.WriteImage(context.Background(), nil, "layer1", meta, "", bytes.NewReader([]byte("content")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if image.MediaType != MediaTypeDockerManifestSchema1 {
+		t.Fatalf("expected MediaType %q, got %q", MediaTypeDockerManifestSchema1, image.MediaType)
+	}
+	if string(image.Metadata[MetadataMediaType]) != MediaTypeDockerManifestSchema1 {
+		t.Fatalf("expected persisted MetadataMediaType %q, got %q", MediaTypeDockerManifestSchema1, image.Metadata[MetadataMediaType])
+	}
+}
+
+func TestNormalizingImageStorerBackfillsMediaType(t *testing.T) {
+	backing := newMemoryImageStorer()
+	store := NewMediaTypeNormalizingImageStorer(backing)
+
+	backing.images["layer1"] = &Image{
+		ID:       "layer1",
+		Metadata: map[string][]byte{MetadataMediaType: []byte(MediaTypeDockerManifestSchema2)},
+	}
+
+	image, err := store.GetImage(context.Background(), nil, "layer1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if image.MediaType != MediaTypeDockerManifestSchema2 {
+		t.Fatalf("expected backfilled MediaType %q, got %q", MediaTypeDockerManifestSchema2, image.MediaType)
+	}
+}
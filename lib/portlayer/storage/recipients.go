@@ -0,0 +1,61 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// ParseRecipients turns a list of "<type>:<path>" strings, as accepted by
+// the --image-encryption-recipient CLI flag, into Recipients. type is
+// "pgp" or "jwe" and path points at the recipient's public key file
+// (armored for pgp, DER/PKIX for jwe).
+func ParseRecipients(raw []string) ([]Recipient, error) {
+	recipients := make([]Recipient, 0, len(raw))
+	for _, r := range raw {
+		recipient, err := parseRecipient(r)
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, recipient)
+	}
+	return recipients, nil
+}
+
+func parseRecipient(raw string) (Recipient, error) {
+	parts := strings.SplitN(raw, ":", 2)
+	if len(parts) != 2 {
+		return Recipient{}, fmt.Errorf("storage: invalid --image-encryption-recipient %q, expected <pgp|jwe>:<path>", raw)
+	}
+
+	var recipientType RecipientType
+	switch parts[0] {
+	case string(RecipientPGP):
+		recipientType = RecipientPGP
+	case string(RecipientJWE):
+		recipientType = RecipientJWE
+	default:
+		return Recipient{}, fmt.Errorf("storage: unknown recipient type %q in --image-encryption-recipient", parts[0])
+	}
+
+	key, err := ioutil.ReadFile(parts[1])
+	if err != nil {
+		return Recipient{}, fmt.Errorf("storage: reading recipient key %q: %v", parts[1], err)
+	}
+
+	return Recipient{Type: recipientType, Key: key}, nil
+}
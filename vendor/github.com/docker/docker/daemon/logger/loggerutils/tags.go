@@ -0,0 +1,53 @@
+// Package loggerutils provides helper functions shared by multiple log
+// drivers.
+package loggerutils
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"text/template"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+// DefaultTemplate is the tag template used by log drivers that don't need
+// to namespace tags beyond the container ID.
+const DefaultTemplate = "{{.ID}}"
+
+// tagContext wraps a logger.Context so that its own fields (ID, Name, ...)
+// stay reachable from the tag template while also exposing fields, such as
+// DaemonName, that aren't part of logger.Context itself.
+type tagContext struct {
+	logger.Context
+	DaemonName string
+}
+
+// ParseLogTag generates a context-dependent tag for a log driver by parsing
+// defaultTemplate, or the user-supplied "tag" log opt when set, and
+// replacing placeholders with values from ctx. In addition to the fields
+// exposed by logger.Context, the template may reference {{.DaemonName}},
+// which resolves to the daemonNameOpt log opt if the caller set it, or the
+// basename of the running binary otherwise.
+func ParseLogTag(ctx logger.Context, daemonNameOpt, defaultTemplate string) (string, error) {
+	tagTemplate := ctx.Config["tag"]
+	if tagTemplate == "" {
+		tagTemplate = defaultTemplate
+	}
+
+	tmpl, err := template.New("log-tag").Parse(tagTemplate)
+	if err != nil {
+		return "", err
+	}
+
+	daemonName := ctx.Config[daemonNameOpt]
+	if daemonName == "" {
+		daemonName = path.Base(os.Args[0])
+	}
+
+	buf := new(bytes.Buffer)
+	if err := tmpl.Execute(buf, &tagContext{Context: ctx, DaemonName: daemonName}); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,52 @@
+package loggerutils
+
+import (
+	"testing"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+func TestParseLogTagDefaultTemplate(t *testing.T) {
+	ctx := logger.Context{
+		Config:        map[string]string{},
+		ContainerID:   "container-id",
+		ContainerName: "/container-name",
+	}
+
+	tag, err := ParseLogTag(ctx, "syslog-daemon-name", DefaultTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag != "container-id" {
+		t.Fatalf("expected tag %q, got %q", "container-id", tag)
+	}
+}
+
+func TestParseLogTagCustomTemplate(t *testing.T) {
+	ctx := logger.Context{
+		Config:      map[string]string{"tag": "{{.Name}}/{{.ID}}"},
+		ContainerID: "container-id",
+	}
+
+	tag, err := ParseLogTag(ctx, "syslog-daemon-name", DefaultTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag != "/container-id" {
+		t.Fatalf("expected tag %q, got %q", "/container-id", tag)
+	}
+}
+
+func TestParseLogTagDaemonNameOnlyTemplate(t *testing.T) {
+	ctx := logger.Context{
+		Config: map[string]string{"syslog-daemon-name": "vic-engine"},
+	}
+
+	tag, err := ParseLogTag(ctx, "syslog-daemon-name", "{{.DaemonName}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag != "vic-engine" {
+		t.Fatalf("expected tag %q, got %q", "vic-engine", tag)
+	}
+}
@@ -10,7 +10,6 @@ import (
 	"net"
 	"net/url"
 	"os"
-	"path"
 	"strconv"
 	"strings"
 	"time"
@@ -27,6 +26,16 @@ import (
 const (
 	name        = "syslog"
 	secureProto = "tcp+tls"
+
+	// daemonNameOpt is the log opt that lets a user override what
+	// {{.DaemonName}} resolves to in the tag template.
+	daemonNameOpt = "syslog-daemon-name"
+
+	// defaultTagTemplate namespaces every tag by the daemon name so
+	// messages from different VCHs/daemons sharing a syslog endpoint
+	// can still be told apart, without forcing it into the tag a
+	// second time the way the old hardcoded prefix did.
+	defaultTagTemplate = "{{.DaemonName}}/{{.ID}}"
 )
 
 var facilities = map[string]syslog.Priority{
@@ -80,7 +89,7 @@ func rfc5424formatterWithAppNameAsTag(p syslog.Priority, hostname, tag, content
 // the context. Supported context configuration variables are
 // syslog-address, syslog-facility, & syslog-tag.
 func New(ctx logger.Context) (logger.Logger, error) {
-	tag, err := loggerutils.ParseLogTag(ctx, "{{.ID}}")
+	logTag, err := loggerutils.ParseLogTag(ctx, daemonNameOpt, defaultTagTemplate)
 	if err != nil {
 		return nil, err
 	}
@@ -100,8 +109,6 @@ func New(ctx logger.Context) (logger.Logger, error) {
 		return nil, err
 	}
 
-	logTag := path.Base(os.Args[0]) + "/" + tag
-
 	var log *syslog.Writer
 	if proto == secureProto {
 		tlsConfig, tlsErr := parseTLSConfig(ctx.Config)
@@ -186,6 +193,7 @@ func ValidateLogOpt(cfg map[string]string) error {
 		case "syslog-tls-skip-verify":
 		case "tag":
 		case "syslog-format":
+		case daemonNameOpt:
 		default:
 			return fmt.Errorf("unknown log opt '%s' for syslog log driver", key)
 		}
@@ -0,0 +1,19 @@
+// +build linux
+
+package syslog
+
+import "testing"
+
+func TestValidateLogOptDaemonName(t *testing.T) {
+	if err := ValidateLogOpt(map[string]string{
+		daemonNameOpt: "vic-engine",
+	}); err != nil {
+		t.Fatalf("expected %s to be a valid log opt, got: %v", daemonNameOpt, err)
+	}
+}
+
+func TestValidateLogOptUnknown(t *testing.T) {
+	if err := ValidateLogOpt(map[string]string{"bogus": "value"}); err == nil {
+		t.Fatal("expected an error for an unknown log opt")
+	}
+}